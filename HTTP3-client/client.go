@@ -7,38 +7,54 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"time"
 
-	"github.com/lucas-clemente/quic-go/http3"
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/Wowah/golang-HTTP3-example/zerortt"
 )
 
-func main() {
-	// Create a pipe - an object that implements `io.Reader` and `io.Writer`.
-	// Whatever is written to the writer part will be read by the reader part.
-	pr, pw := io.Pipe()
+// sessionCachePath is where TLS session tickets are persisted between
+// runs, so that http3.MethodGet0RTT has something to resume from on
+// the very first connection attempt of a fresh process, instead of
+// only after the process has been running long enough to cache one
+// in memory.
+const sessionCachePath = "http3-client-sessiontickets.gob"
 
-	// Create an `http.Request` and set its body as the reader part of the
-	// pipe - after sending the request, whatever will be written to the pipe,
-	// will be sent as the request body.
-	// This makes the request content dynamic, so we don't need to define it
-	// before sending the request.
-	req, err := http.NewRequest(http3.MethodGet0RTT, "https://localhost:8081", ioutil.NopCloser(pr))
+func main() {
+	sessionCache, err := zerortt.NewFileSessionCache(sessionCachePath, "quic-echo-example", 24*time.Hour)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Send the request
 	client := http.Client{
 		Transport: &http3.RoundTripper{
 			TLSClientConfig: &tls.Config{
 				InsecureSkipVerify: true,
+				ClientSessionCache: sessionCache,
 			},
 		},
 	}
-	resp, err := client.Do(req)
-	log.Printf("Request was sent")
+
+	// The server's PING/PONG handler is stateful, so it's marked
+	// non-idempotent and rejects 0-RTT requests with 425 Too Early
+	// once a session ticket lets us resume into one - which, thanks
+	// to sessionCache, is every run after the first. Try 0-RTT first
+	// since that's the whole point of caching a ticket, but fall back
+	// to an ordinary 1-RTT request so the demo keeps working instead
+	// of silently going quiet after its first success.
+	pw, resp, err := sendRequest(&client, http3.MethodGet0RTT)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if resp.StatusCode == http.StatusTooEarly {
+		resp.Body.Close()
+		log.Printf("Server rejected 0-RTT request as too early, retrying over 1-RTT")
+		pw, resp, err = sendRequest(&client, http.MethodGet)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 	log.Printf("Got: %d", resp.StatusCode)
 
 	str := resp.Body
@@ -61,3 +77,23 @@ func main() {
 		fmt.Fprintf(pw, "PONG")
 	}
 }
+
+// sendRequest issues a request over client with the given method,
+// giving it a fresh pipe as its body so the caller can stream PONGs
+// back once the response arrives - this makes the request content
+// dynamic, so we don't need to define it before sending the request.
+func sendRequest(client *http.Client, method string) (*io.PipeWriter, *http.Response, error) {
+	pr, pw := io.Pipe()
+
+	req, err := http.NewRequest(method, "https://localhost:8081", ioutil.NopCloser(pr))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := client.Do(req)
+	log.Printf("Request was sent")
+	if err != nil {
+		return nil, nil, err
+	}
+	return pw, resp, nil
+}
@@ -15,19 +15,47 @@ import (
 	_ "net/http/pprof"
 	"time"
 
-	"github.com/lucas-clemente/quic-go"
-	"github.com/lucas-clemente/quic-go/http3"
-	"github.com/lucas-clemente/quic-go/quicvarint"
+	"github.com/quic-go/qpack"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/quicvarint"
+
+	"github.com/Wowah/golang-HTTP3-example/stream"
+	"github.com/Wowah/golang-HTTP3-example/zerortt"
+)
+
+// HTTP3 frame types we care about. Anything else - PUSH_PROMISE,
+// SETTINGS, reserved greasing types, future extensions - is skipped
+// per the HTTP/3 spec's "reserved frame types must be ignored" rule.
+// See: https://tools.ietf.org/id/draft-ietf-quic-http-23.html#rfc.section.7
+const (
+	frameTypeData    = 0x0
+	frameTypeHeaders = 0x1
 )
 
+// defaultWriteBufferSize is how much Write will coalesce before
+// flushing a DATA frame, to avoid sending one frame per small Write
+// call.
+const defaultWriteBufferSize = 4096
+
+// maxTrailerSize bounds how large a HEADERS frame's declared length
+// may be before Read will allocate a buffer for it. The length is an
+// attacker-controlled varint read straight off the wire (up to
+// ~2^62), so without a cap a peer can make the server OOM or panic
+// with "makeslice: len out of range" by claiming a huge trailer
+// block - the same concern http3.Server.maxHeaderBytes addresses for
+// the real HEADERS path.
+const maxTrailerSize = 64 * 1024
+
 // dataFrame - struct for description HTTP3 data frame. See: https://tools.ietf.org/id/draft-ietf-quic-http-23.html#rfc.section.7
 type dataFrame struct {
 	Length uint64
 }
 
 func (f *dataFrame) Write(b *bytes.Buffer) {
-	quicvarint.Write(b, 0x0)
-	quicvarint.Write(b, f.Length)
+	buf := quicvarint.Append(nil, frameTypeData)
+	buf = quicvarint.Append(buf, f.Length)
+	b.Write(buf)
 }
 
 // byteReader - interface for reading bit by bit. Need in function quicvarint.Read
@@ -52,64 +80,158 @@ func (br *byteReaderImpl) ReadByte() (byte, error) {
 type RWStream interface {
 	io.WriteCloser
 	io.Reader
+
+	// Flush forces any data buffered by Write out as a DATA frame
+	// without waiting for the buffer to fill.
+	Flush() error
+
+	// Trailers returns the header fields carried by a trailing
+	// HEADERS frame, once Read has surfaced one. It returns nil if
+	// no trailers have been seen (yet).
+	Trailers() http.Header
 }
 
 // RWStreamImpl - implementation of RWStream interface
 type RWStreamImp struct {
 	str quic.Stream
+
+	// bytesRemainingInFrame carries over across Read calls so a
+	// DATA frame larger than the caller's buffer - or several DATA
+	// frames in a row - is handled without re-entering frame parsing
+	// on every call.
+	bytesRemainingInFrame uint64
+	trailers              http.Header
+
+	writeBuf    bytes.Buffer
+	writeBufCap int
 }
 
 func (w *RWStreamImp) Write(p []byte) (int, error) {
-	df := &dataFrame{Length: uint64(len(p))}
-	buf := &bytes.Buffer{}
-	df.Write(buf)
-	if _, err := w.str.Write(buf.Bytes()); err != nil {
-		return 0, err
+	w.writeBuf.Write(p)
+	if w.writeBuf.Len() >= w.writeBufCap {
+		if err := w.Flush(); err != nil {
+			return 0, err
+		}
 	}
-	return w.str.Write(p)
+	return len(p), nil
 }
 
-func (w *RWStreamImp) Read(p []byte) (int, error) {
-	var bytesRemainingInFrame uint64
+// Flush sends any buffered bytes as a single DATA frame. The header
+// and payload are assembled into one buffer and written with a single
+// Write call - stream.SafeStream only serializes one Write at a time
+// against a concurrent Close, so two separate Write calls here could
+// still be split by a Close landing between them, sending the peer a
+// frame header that promises a payload which never arrives.
+func (w *RWStreamImp) Flush() error {
+	if w.writeBuf.Len() == 0 {
+		return nil
+	}
+
+	df := &dataFrame{Length: uint64(w.writeBuf.Len())}
+	frame := &bytes.Buffer{}
+	df.Write(frame)
+	frame.Write(w.writeBuf.Bytes())
 
-	// Read HTTP3 frame
+	if _, err := w.str.Write(frame.Bytes()); err != nil {
+		return err
+	}
+	w.writeBuf.Reset()
+	return nil
+}
 
+func (w *RWStreamImp) Read(p []byte) (int, error) {
 	br, ok := w.str.(byteReader)
 	if !ok {
 		br = &byteReaderImpl{w.str}
 	}
-	t, err := quicvarint.Read(br)
-	if err != nil {
-		return 0, err
-	}
-	l, err := quicvarint.Read(br)
-	if err != nil {
-		return 0, err
-	}
 
-	// Receive only HTTP3 data frames
-	if t != 0x0 {
-		return 0, fmt.Errorf("Incorrect HTTP3 frame type! Expected: Data frame (0x0). Got: %x", t)
-	}
+	for w.bytesRemainingInFrame == 0 {
+		t, err := quicvarint.Read(br)
+		if err != nil {
+			return 0, err
+		}
+		l, err := quicvarint.Read(br)
+		if err != nil {
+			return 0, err
+		}
 
-	bytesRemainingInFrame = l
+		switch t {
+		case frameTypeData:
+			w.bytesRemainingInFrame = l
+		case frameTypeHeaders:
+			if l > maxTrailerSize {
+				return 0, fmt.Errorf("trailer frame too large: %d bytes", l)
+			}
+			raw := make([]byte, l)
+			if _, err := io.ReadFull(w.str, raw); err != nil {
+				return 0, err
+			}
+			trailers, err := decodeHeaderFields(raw)
+			if err != nil {
+				return 0, fmt.Errorf("decoding trailers: %w", err)
+			}
+			w.trailers = trailers
+		default:
+			// Unknown or reserved frame type: skip its payload and
+			// keep looking for a DATA frame.
+			if _, err := io.CopyN(io.Discard, w.str, int64(l)); err != nil {
+				return 0, err
+			}
+		}
+	}
 
 	var n int
-	if bytesRemainingInFrame < uint64(len(p)) {
-		n, err = w.str.Read(p[:bytesRemainingInFrame])
+	var err error
+	if w.bytesRemainingInFrame < uint64(len(p)) {
+		n, err = w.str.Read(p[:w.bytesRemainingInFrame])
 	} else {
 		n, err = w.str.Read(p)
 	}
+	w.bytesRemainingInFrame -= uint64(n)
 	return n, err
 }
 
+func (w *RWStreamImp) Trailers() http.Header {
+	return w.trailers
+}
+
 func (w *RWStreamImp) Close() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
 	return w.str.Close()
 }
 
+// decodeHeaderFields QPACK-decodes a complete HEADERS frame payload
+// into an http.Header. It assumes raw contains a full field section
+// with no references into the dynamic table, which holds for the
+// trailers this example sends.
+func decodeHeaderFields(raw []byte) (http.Header, error) {
+	hdr := make(http.Header)
+	decoder := qpack.NewDecoder(func(f qpack.HeaderField) {
+		hdr.Add(f.Name, f.Value)
+	})
+	if _, err := decoder.Write(raw); err != nil {
+		return nil, err
+	}
+	return hdr, nil
+}
+
+// NewRWStream wraps str in a stream.SafeStream before handing it to
+// RWStreamImp, so Close can race with an in-flight Write or with the
+// peer's own half of the close without corrupting the connection. It
+// uses defaultWriteBufferSize for write coalescing; use
+// NewRWStreamSize to configure it.
 func NewRWStream(str quic.Stream) RWStream {
+	return NewRWStreamSize(str, defaultWriteBufferSize)
+}
+
+// NewRWStreamSize is like NewRWStream but lets the caller pick the
+// write coalescing buffer size.
+func NewRWStreamSize(str quic.Stream, writeBufCap int) RWStream {
 	return &RWStreamImp{
-		str: str,
+		str:         stream.NewSafeStream(str),
+		writeBufCap: writeBufCap,
 	}
 }
 
@@ -147,7 +269,7 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusSwitchingProtocols)
 
 	// From this moment on, the management of the QUIC streamer is entirely on the server's shoulders
-	str := w.(http3.DataStreamer).DataStream()
+	str := w.(http3.HTTPStreamer).HTTPStream()
 
 	RWStr := NewRWStream(str)
 	defer RWStr.Close()
@@ -159,6 +281,10 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			log.Printf("Error while writing message in stream. Error: %v", err)
 			return
 		}
+		if err := RWStr.Flush(); err != nil {
+			log.Printf("Error while flushing message to stream. Error: %v", err)
+			return
+		}
 
 		log.Printf("Ping message was successfully sent")
 
@@ -173,13 +299,27 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// logZeroRTTMetrics periodically logs the 0-RTT accept/reject/replay
+// counters so operators can actually observe the rollout zeroRTTMetrics
+// is tracking, instead of them sitting write-only in memory.
+func logZeroRTTMetrics(m *zerortt.Metrics) {
+	for range time.Tick(30 * time.Second) {
+		log.Printf("0-RTT metrics: accepted=%d rejected=%d replayed=%d", m.Accepted(), m.Rejected(), m.Replayed())
+	}
+}
+
 func main() {
+	// The ping-pong handler drives 1:1 stateful conversation with the
+	// client, so it isn't safe to run twice - mark it non-idempotent
+	// and let 0-RTT requests be rejected with 425 Too Early instead.
+	zeroRTTMetrics := &zerortt.Metrics{}
+	handler := zerortt.RequireReplaySafe(&Server{}, false, zeroRTTMetrics)
+	go logZeroRTTMetrics(zeroRTTMetrics)
+
 	server := http3.Server{
-		Server: &http.Server{
-			Addr:      "localhost:8081",
-			Handler:   &Server{},
-			TLSConfig: generateTLSConfig(),
-		},
+		Addr:      "localhost:8081",
+		Handler:   handler,
+		TLSConfig: generateTLSConfig(),
 	}
 
 	err := server.ListenAndServe()
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/quic-go/qpack"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+// fakeRWStream is a minimal quic.Stream backed by an in-memory buffer,
+// so RWStreamImp's frame-parsing state machine can be driven without a
+// real QUIC connection. writeCalls counts how many times the
+// underlying Write is invoked, so Flush's single-write-per-frame
+// invariant can be checked directly.
+type fakeRWStream struct {
+	quic.Stream
+
+	buf        bytes.Buffer
+	writeCalls int
+}
+
+func (f *fakeRWStream) Read(p []byte) (int, error) { return f.buf.Read(p) }
+
+func (f *fakeRWStream) Write(p []byte) (int, error) {
+	f.writeCalls++
+	return f.buf.Write(p)
+}
+
+func (f *fakeRWStream) Close() error                     { return nil }
+func (f *fakeRWStream) CancelRead(quic.StreamErrorCode)  {}
+func (f *fakeRWStream) CancelWrite(quic.StreamErrorCode) {}
+
+// appendFrame appends a raw frame (type + length + payload) to buf,
+// the same wire shape dataFrame.Write produces for DATA frames.
+func appendFrame(buf *bytes.Buffer, frameType uint64, payload []byte) {
+	header := quicvarint.Append(nil, frameType)
+	header = quicvarint.Append(header, uint64(len(payload)))
+	buf.Write(header)
+	buf.Write(payload)
+}
+
+func TestFlushWritesHeaderAndPayloadInOneWrite(t *testing.T) {
+	fs := &fakeRWStream{}
+	w := &RWStreamImp{str: fs, writeBufCap: defaultWriteBufferSize}
+
+	if _, err := w.Write([]byte("PING")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if fs.writeCalls != 0 {
+		t.Fatalf("Write below writeBufCap should not flush, got %d underlying writes", fs.writeCalls)
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if fs.writeCalls != 1 {
+		t.Fatalf("Flush should combine the frame header and payload into one Write, got %d", fs.writeCalls)
+	}
+
+	var want bytes.Buffer
+	appendFrame(&want, frameTypeData, []byte("PING"))
+	if !bytes.Equal(fs.buf.Bytes(), want.Bytes()) {
+		t.Fatalf("Flush wrote %x, want %x", fs.buf.Bytes(), want.Bytes())
+	}
+}
+
+func TestWriteFlushesAutomaticallyOnceBufferFull(t *testing.T) {
+	fs := &fakeRWStream{}
+	w := &RWStreamImp{str: fs, writeBufCap: 4}
+
+	if _, err := w.Write([]byte("PING")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if fs.writeCalls != 1 {
+		t.Fatalf("expected Write to flush once writeBufCap is reached, got %d underlying writes", fs.writeCalls)
+	}
+}
+
+func TestReadReturnsDataFramePayload(t *testing.T) {
+	fs := &fakeRWStream{}
+	appendFrame(&fs.buf, frameTypeData, []byte("PONG"))
+	w := &RWStreamImp{str: fs}
+
+	buf := make([]byte, 4)
+	n, err := w.Read(buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if string(buf[:n]) != "PONG" {
+		t.Fatalf("Read() = %q, want %q", buf[:n], "PONG")
+	}
+}
+
+func TestReadSkipsUnknownFrameType(t *testing.T) {
+	fs := &fakeRWStream{}
+	appendFrame(&fs.buf, 0x2, []byte("ignored")) // reserved/unknown frame type
+	appendFrame(&fs.buf, frameTypeData, []byte("PONG"))
+	w := &RWStreamImp{str: fs}
+
+	buf := make([]byte, 4)
+	n, err := w.Read(buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if string(buf[:n]) != "PONG" {
+		t.Fatalf("Read() = %q, want %q after skipping the unknown frame", buf[:n], "PONG")
+	}
+}
+
+func TestReadDecodesTrailersFromHeadersFrame(t *testing.T) {
+	var headerPayload bytes.Buffer
+	enc := qpack.NewEncoder(&headerPayload)
+	if err := enc.WriteField(qpack.HeaderField{Name: "trailer-key", Value: "trailer-value"}); err != nil {
+		t.Fatalf("encoding trailer field: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("closing encoder: %v", err)
+	}
+
+	fs := &fakeRWStream{}
+	appendFrame(&fs.buf, frameTypeHeaders, headerPayload.Bytes())
+	appendFrame(&fs.buf, frameTypeData, []byte("PONG"))
+	w := &RWStreamImp{str: fs}
+
+	buf := make([]byte, 4)
+	if _, err := w.Read(buf); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	trailers := w.Trailers()
+	if got := trailers.Get("trailer-key"); got != "trailer-value" {
+		t.Fatalf("Trailers().Get(%q) = %q, want %q", "trailer-key", got, "trailer-value")
+	}
+}
@@ -0,0 +1,334 @@
+// Package doh3 implements a DNS-over-HTTPS (RFC 8484) client that runs
+// over HTTP/3, built on the same http3.RoundTripper the rest of this
+// repo uses for its QUIC examples.
+package doh3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// methodRawStream is the HTTP method exchangeStream sends to trigger
+// the StatusSwitchingProtocols raw-stream upgrade, mirroring the
+// HTTP3-server example's handler. It is deliberately its own method
+// rather than a reuse of http3.MethodGet0RTT: the upgrade has nothing
+// to do with 0-RTT or replay safety (see the zerortt package for the
+// real thing), it's just an unused verb the server's mux can key off
+// of to pick the raw-stream code path instead of a normal POST.
+const methodRawStream = "DOH3_RAWSTREAM"
+
+// dnsMessageContentType is the media type RFC 8484 defines for the
+// DNS wire format carried in the request and response bodies.
+const dnsMessageContentType = "application/dns-message"
+
+// maxMessageSize is the largest DNS message we're willing to read
+// back from a server, matching the RFC 8484 guidance that responses
+// fit comfortably within a single UDP-sized payload even over HTTP/3.
+const maxMessageSize = 64 * 1024
+
+// Config holds the knobs needed to stand up a Client.
+type Config struct {
+	// ServerURL is the DoH3 endpoint to POST queries to, e.g.
+	// "https://dns.example.com/dns-query".
+	ServerURL string
+
+	// ServerName overrides the TLS SNI sent to the server. If empty,
+	// it is derived from ServerURL.
+	ServerName string
+
+	// ALPN overrides the TLS protocols offered during the handshake.
+	// If empty, http3's default "h3" is used.
+	ALPN []string
+
+	// QUICConfig is passed through to the underlying
+	// http3.RoundTripper unmodified.
+	QUICConfig *quic.Config
+
+	// PinnedSPKI, if set, is the SHA-256 hash of the server
+	// certificate's SubjectPublicKeyInfo. The handshake is failed if
+	// the presented leaf certificate doesn't match.
+	PinnedSPKI []byte
+
+	// Fallback, if true, makes Exchange open a raw bidirectional
+	// QUIC stream instead of issuing a standard POST, for servers
+	// that expose DoH3 as an upgraded stream (see exchangeStream).
+	Fallback bool
+}
+
+// Client is a DoH3 resolver. It reuses a single http3.RoundTripper
+// (and therefore a single QUIC connection) across queries, and caches
+// responses for their answer TTL - clamped by any Cache-Control
+// max-age, no-cache, or no-store the server sets on its HTTP response,
+// since that response may legitimately be less cacheable than the DNS
+// answer TTLs alone suggest. A Client is safe for concurrent use by
+// multiple goroutines.
+type Client struct {
+	cfg Config
+	rt  *http3.RoundTripper
+	hc  *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	msg     *dns.Msg
+	expires time.Time
+}
+
+// NewClient builds a Client from cfg. The returned Client owns the
+// underlying QUIC connection(s) and should be closed with Close once
+// it's no longer needed.
+func NewClient(cfg Config) (*Client, error) {
+	tlsConfig := &tls.Config{
+		ServerName: cfg.ServerName,
+	}
+	if len(cfg.ALPN) > 0 {
+		tlsConfig.NextProtos = cfg.ALPN
+	}
+	if len(cfg.PinnedSPKI) > 0 {
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = pinnedSPKIVerifier(cfg.PinnedSPKI)
+	}
+
+	rt := &http3.RoundTripper{
+		TLSClientConfig: tlsConfig,
+		QUICConfig:      cfg.QUICConfig,
+	}
+
+	return &Client{
+		cfg:   cfg,
+		rt:    rt,
+		hc:    &http.Client{Transport: rt},
+		cache: make(map[string]cacheEntry),
+	}, nil
+}
+
+// Close tears down the underlying QUIC connection(s).
+func (c *Client) Close() error {
+	return c.rt.Close()
+}
+
+// Exchange sends req and returns the server's response, consulting
+// and populating the TTL-based cache along the way.
+func (c *Client) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	key, err := cacheKey(req)
+	if err != nil {
+		return nil, fmt.Errorf("doh3: computing cache key: %w", err)
+	}
+
+	if cached := c.lookup(key); cached != nil {
+		resp := cached.Copy()
+		resp.Id = req.Id
+		return resp, nil
+	}
+
+	wire, err := req.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("doh3: packing query: %w", err)
+	}
+
+	var respWire []byte
+	var respHeader http.Header
+	if c.cfg.Fallback {
+		respWire, err = c.exchangeStream(ctx, wire)
+	} else {
+		respWire, respHeader, err = c.exchangePost(ctx, wire)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp := new(dns.Msg)
+	if err := resp.Unpack(respWire); err != nil {
+		return nil, fmt.Errorf("doh3: unpacking response: %w", err)
+	}
+
+	c.store(key, resp, respHeader)
+
+	return resp, nil
+}
+
+// exchangePost performs the standard RFC 8484 exchange: a POST of the
+// DNS wire format with the dns-message content type. It returns the
+// response header alongside the body so the caller can honor any
+// Cache-Control the server set on top of the DNS answer TTLs.
+func (c *Client) exchangePost(ctx context.Context, wire []byte) ([]byte, http.Header, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.ServerURL, bytes.NewReader(wire))
+	if err != nil {
+		return nil, nil, fmt.Errorf("doh3: building request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", dnsMessageContentType)
+	httpReq.Header.Set("Accept", dnsMessageContentType)
+
+	resp, err := c.hc.Do(httpReq)
+	if err != nil {
+		return nil, nil, fmt.Errorf("doh3: round trip: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("doh3: server returned status %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxMessageSize))
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, resp.Header, nil
+}
+
+// exchangeStream is a fallback for servers that expose DoH3 over a
+// raw bidirectional QUIC stream rather than a standard POST: it
+// upgrades the request the same way the echo server in this repo
+// does (StatusSwitchingProtocols + HTTPStreamer), then frames the
+// query as a single HTTP/3 DATA frame before reading the framed
+// response back.
+func (c *Client) exchangeStream(ctx context.Context, wire []byte) ([]byte, error) {
+	pr, pw := io.Pipe()
+
+	httpReq, err := http.NewRequestWithContext(ctx, methodRawStream, c.cfg.ServerURL, ioutil.NopCloser(pr))
+	if err != nil {
+		return nil, fmt.Errorf("doh3: building stream request: %w", err)
+	}
+
+	resp, err := c.hc.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("doh3: round trip: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("doh3: server did not upgrade to a raw stream (status %d)", resp.StatusCode)
+	}
+
+	if _, err := writeDataFrame(pw, wire); err != nil {
+		pw.CloseWithError(err)
+		return nil, fmt.Errorf("doh3: writing query frame: %w", err)
+	}
+	pw.Close()
+
+	return readDataFrame(resp.Body, maxMessageSize)
+}
+
+// cacheKey identifies a DNS query by its question section only, so
+// that a cached answer can be replayed for any query ID and with the
+// EDNS/DO bits already negotiated away.
+func cacheKey(msg *dns.Msg) (string, error) {
+	if len(msg.Question) != 1 {
+		return "", fmt.Errorf("doh3: expected exactly one question, got %d", len(msg.Question))
+	}
+	q := msg.Question[0]
+	return fmt.Sprintf("%s|%d|%d", q.Name, q.Qtype, q.Qclass), nil
+}
+
+func (c *Client) lookup(key string) *dns.Msg {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.cache[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.cache, key)
+		return nil
+	}
+	return entry.msg
+}
+
+// store caches msg for the smaller of its answer TTLs and any
+// Cache-Control max-age on header, the HTTP response msg was carried
+// in. header is nil for the exchangeStream fallback, which has no
+// HTTP response of its own to set Cache-Control on.
+func (c *Client) store(key string, msg *dns.Msg, header http.Header) {
+	ttl := minTTL(msg)
+	if header != nil {
+		if maxAge, ok := cacheControlMaxAge(header); ok && maxAge < ttl {
+			ttl = maxAge
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cache[key] = cacheEntry{
+		msg:     msg.Copy(),
+		expires: time.Now().Add(time.Duration(ttl) * time.Second),
+	}
+}
+
+// minTTL returns the smallest TTL among msg's answer records, which
+// is the safe upper bound for how long the whole answer may be
+// cached.
+func minTTL(msg *dns.Msg) uint32 {
+	var ttl uint32
+	for i, rr := range msg.Answer {
+		h := rr.Header()
+		if i == 0 || h.Ttl < ttl {
+			ttl = h.Ttl
+		}
+	}
+	return ttl
+}
+
+// cacheControlMaxAge parses header's Cache-Control for a max-age
+// directive, returning (ttl, true) if one is present. no-store and
+// no-cache are reported as max-age=0, since either means the response
+// must not be reused for a later query.
+func cacheControlMaxAge(header http.Header) (uint32, bool) {
+	for _, part := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive := strings.TrimSpace(part)
+		if strings.EqualFold(directive, "no-store") || strings.EqualFold(directive, "no-cache") {
+			return 0, true
+		}
+
+		name, value, found := strings.Cut(directive, "=")
+		if !found || !strings.EqualFold(strings.TrimSpace(name), "max-age") {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(value), 10, 32)
+		if err != nil {
+			continue
+		}
+		return uint32(n), true
+	}
+	return 0, false
+}
+
+// pinnedSPKIVerifier returns a tls.Config.VerifyPeerCertificate
+// callback that accepts the handshake only if the leaf certificate's
+// SubjectPublicKeyInfo hashes to pinned.
+func pinnedSPKIVerifier(pinned []byte) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("doh3: no certificate presented")
+		}
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return fmt.Errorf("doh3: parsing leaf certificate: %w", err)
+		}
+		sum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+		if !bytes.Equal(sum[:], pinned) {
+			return fmt.Errorf("doh3: certificate SPKI pin mismatch")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,78 @@
+package doh3
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestCacheControlMaxAge(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		wantTTL uint32
+		wantOK  bool
+	}{
+		{name: "no header", header: "", wantOK: false},
+		{name: "max-age", header: "max-age=120", wantTTL: 120, wantOK: true},
+		{name: "max-age with other directives", header: "public, max-age=30", wantTTL: 30, wantOK: true},
+		{name: "no-store", header: "no-store", wantTTL: 0, wantOK: true},
+		{name: "no-cache", header: "no-cache", wantTTL: 0, wantOK: true},
+		{name: "unparseable max-age", header: "max-age=soon", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := make(http.Header)
+			if tt.header != "" {
+				h.Set("Cache-Control", tt.header)
+			}
+
+			ttl, ok := cacheControlMaxAge(h)
+			if ok != tt.wantOK {
+				t.Fatalf("cacheControlMaxAge() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && ttl != tt.wantTTL {
+				t.Fatalf("cacheControlMaxAge() ttl = %d, want %d", ttl, tt.wantTTL)
+			}
+		})
+	}
+}
+
+func TestStoreClampsTTLToCacheControl(t *testing.T) {
+	c := &Client{cache: make(map[string]cacheEntry)}
+
+	msg := new(dns.Msg)
+	msg.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Ttl: 300}}}
+
+	header := make(http.Header)
+	header.Set("Cache-Control", "max-age=5")
+
+	c.store("key", msg, header)
+
+	entry, ok := c.cache["key"]
+	if !ok {
+		t.Fatal("expected entry to be cached")
+	}
+	if until := time.Until(entry.expires); until > 6*time.Second {
+		t.Fatalf("expires in %v, want clamped to ~5s by Cache-Control", until)
+	}
+}
+
+func TestStoreSkipsCachingOnNoStore(t *testing.T) {
+	c := &Client{cache: make(map[string]cacheEntry)}
+
+	msg := new(dns.Msg)
+	msg.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Ttl: 300}}}
+
+	header := make(http.Header)
+	header.Set("Cache-Control", "no-store")
+
+	c.store("key", msg, header)
+
+	if _, ok := c.cache["key"]; ok {
+		t.Fatal("expected no-store to prevent caching regardless of DNS TTL")
+	}
+}
@@ -0,0 +1,73 @@
+package doh3
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+// writeDataFrame frames p as a single HTTP/3 DATA frame (type 0x0),
+// mirroring the framing HTTP3-server uses on its raw QUIC streams.
+// See: https://tools.ietf.org/id/draft-ietf-quic-http-23.html#rfc.section.7
+func writeDataFrame(w io.Writer, p []byte) (int, error) {
+	header := quicvarint.Append(nil, 0x0)
+	header = quicvarint.Append(header, uint64(len(p)))
+
+	if _, err := w.Write(header); err != nil {
+		return 0, err
+	}
+	return w.Write(p)
+}
+
+// readDataFrame reads a single HTTP/3 DATA frame from r and returns
+// its payload, erroring on any other frame type. maxSize bounds the
+// frame's declared length: it's an untrusted varint read straight off
+// the wire (up to ~2^62), so without a cap a malicious or misbehaving
+// peer could make the payload allocation below consume an arbitrary
+// amount of memory.
+func readDataFrame(r io.Reader, maxSize uint64) ([]byte, error) {
+	br, ok := r.(byteReader)
+	if !ok {
+		br = &byteReaderImpl{r}
+	}
+
+	t, err := quicvarint.Read(br)
+	if err != nil {
+		return nil, err
+	}
+	if t != 0x0 {
+		return nil, fmt.Errorf("doh3: expected DATA frame (0x0), got %x", t)
+	}
+	l, err := quicvarint.Read(br)
+	if err != nil {
+		return nil, err
+	}
+	if l > maxSize {
+		return nil, fmt.Errorf("doh3: DATA frame too large: %d bytes", l)
+	}
+
+	payload := make([]byte, l)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// byteReader is the interface quicvarint.Read needs to peel off a
+// varint one byte at a time.
+type byteReader interface {
+	io.ByteReader
+	io.Reader
+}
+
+// byteReaderImpl adapts a plain io.Reader to byteReader.
+type byteReaderImpl struct{ io.Reader }
+
+func (br *byteReaderImpl) ReadByte() (byte, error) {
+	b := make([]byte, 1)
+	if _, err := br.Reader.Read(b); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
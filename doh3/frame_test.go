@@ -0,0 +1,45 @@
+package doh3
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+func TestDataFrameRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	want := []byte("hello doh3")
+
+	if _, err := writeDataFrame(&buf, want); err != nil {
+		t.Fatalf("writeDataFrame: %v", err)
+	}
+
+	got, err := readDataFrame(&buf, maxMessageSize)
+	if err != nil {
+		t.Fatalf("readDataFrame: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("readDataFrame() = %q, want %q", got, want)
+	}
+}
+
+func TestReadDataFrameRejectsNonDataFrameType(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteByte(0x1) // HEADERS frame type, not DATA (0x0)
+	buf.WriteByte(0x0) // zero-length payload
+
+	if _, err := readDataFrame(&buf, maxMessageSize); err == nil {
+		t.Fatal("expected an error for a non-DATA frame type")
+	}
+}
+
+func TestReadDataFrameRejectsOversizedLength(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteByte(0x0) // DATA frame type
+	buf.Write(quicvarint.Append(nil, maxMessageSize+1))
+
+	if _, err := readDataFrame(&buf, maxMessageSize); err == nil {
+		t.Fatal("expected an error for a frame length over maxSize")
+	}
+}
@@ -0,0 +1,72 @@
+// Package stream provides helpers for safely driving raw QUIC streams
+// that have been taken over from HTTP/3 request handling (see
+// http3.Hijacker / http3.HTTPStreamer), where the usual net/http
+// lifecycle no longer applies.
+package stream
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+)
+
+// cancelReadErrorCode is the application error code sent to the peer
+// when SafeStream eagerly cancels the receive side on Close.
+const cancelReadErrorCode = 0x100
+
+// ErrStreamClosed is returned by Write once the stream has been
+// closed.
+var ErrStreamClosed = errors.New("stream: write on closed stream")
+
+// SafeStream wraps a quic.Stream to make it safe for concurrent use
+// from a reader and a writer goroutine.
+//
+// quic.Stream.Close must not be called concurrently with Write, and it
+// only shuts down the send side - left unchecked, the receive side
+// keeps its buffers alive until the peer FINs or the connection dies.
+// SafeStream serializes Write and Close under a mutex, makes Close
+// idempotent so both halves of a connection can close it without
+// racing each other, and cancels the receive side on Close to release
+// those buffers eagerly.
+type SafeStream struct {
+	quic.Stream
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewSafeStream wraps str so that Write and Close are safe to call
+// concurrently with each other and from either side of the stream.
+func NewSafeStream(str quic.Stream) *SafeStream {
+	return &SafeStream{Stream: str}
+}
+
+// Write serializes writes against Close so a write is never issued on
+// an already-closed (or closing) stream.
+func (s *SafeStream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, ErrStreamClosed
+	}
+	return s.Stream.Write(p)
+}
+
+// Close shuts down the send side of the stream and cancels the
+// receive side so the peer stops trying to deliver more data. It is
+// idempotent: calling it more than once (e.g. once from the reader
+// goroutine noticing EOF and once from a deferred close) is safe and
+// only the first call does any work.
+func (s *SafeStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	err := s.Stream.Close()
+	s.Stream.CancelRead(cancelReadErrorCode)
+	return err
+}
@@ -0,0 +1,119 @@
+package stream
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// fakeStream is a minimal quic.Stream that records whether Close raced
+// a Write, and how many times Close/CancelRead ran.
+type fakeStream struct {
+	mu sync.Mutex
+	quic.Stream
+
+	writeBusy   bool
+	raced       bool
+	closeCalls  int
+	cancelReads int
+}
+
+func (f *fakeStream) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	if f.writeBusy {
+		f.raced = true
+	}
+	f.writeBusy = true
+	f.mu.Unlock()
+
+	// Give a concurrent Close a chance to run while this Write is
+	// still "in flight", the way a real quic.Stream.Write would hold
+	// the send side busy.
+	time.Sleep(time.Millisecond)
+
+	f.mu.Lock()
+	f.writeBusy = false
+	f.mu.Unlock()
+	return len(p), nil
+}
+
+func (f *fakeStream) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.writeBusy {
+		f.raced = true
+	}
+	f.closeCalls++
+	return nil
+}
+
+func (f *fakeStream) CancelRead(quic.StreamErrorCode) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cancelReads++
+}
+
+func (f *fakeStream) Read(p []byte) (int, error) { return 0, nil }
+
+func TestSafeStreamSerializesWriteAndClose(t *testing.T) {
+	fs := &fakeStream{}
+	s := NewSafeStream(fs)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		s.Write([]byte("hello"))
+	}()
+	go func() {
+		defer wg.Done()
+		s.Close()
+	}()
+
+	wg.Wait()
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.raced {
+		t.Fatal("Write and Close were not serialized against each other")
+	}
+}
+
+func TestSafeStreamCloseIsIdempotent(t *testing.T) {
+	fs := &fakeStream{}
+	s := NewSafeStream(fs)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Close()
+		}()
+	}
+	wg.Wait()
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.closeCalls != 1 {
+		t.Fatalf("expected exactly one underlying Close, got %d", fs.closeCalls)
+	}
+	if fs.cancelReads != 1 {
+		t.Fatalf("expected exactly one CancelRead, got %d", fs.cancelReads)
+	}
+}
+
+func TestSafeStreamWriteAfterCloseFails(t *testing.T) {
+	fs := &fakeStream{}
+	s := NewSafeStream(fs)
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := s.Write([]byte("too late")); err != ErrStreamClosed {
+		t.Fatalf("expected ErrStreamClosed, got %v", err)
+	}
+}
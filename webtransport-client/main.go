@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/Wowah/golang-HTTP3-example/webtransport"
+)
+
+func main() {
+	tlsConf := &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"h3"},
+	}
+
+	ctx := context.Background()
+	session, err := webtransport.Dial(ctx, "localhost:8082", tlsConf, nil, "https://localhost:8082")
+	if err != nil {
+		log.Fatalf("Error while dialing session. Error: %v", err)
+	}
+	defer session.CloseWithError(0, "done")
+
+	log.Printf("Session established")
+
+	// Bulk transfer goes over a stream.
+	go func() {
+		str, err := session.OpenStreamSync(ctx)
+		if err != nil {
+			log.Printf("Error while opening stream. Error: %v", err)
+			return
+		}
+		defer str.Close()
+
+		if _, err := str.Write([]byte("hello over a stream")); err != nil {
+			log.Printf("Error while writing to stream. Error: %v", err)
+			return
+		}
+
+		buf := make([]byte, 64)
+		n, err := str.Read(buf)
+		if err != nil && err != io.EOF {
+			log.Printf("Error while reading from stream. Error: %v", err)
+			return
+		}
+		log.Printf("Echoed back over stream: %s", buf[:n])
+	}()
+
+	// Latency-sensitive messages go over datagrams.
+	for i := 0; i < 10; i++ {
+		msg := []byte(fmt.Sprintf("ping %d", i))
+		if err := session.SendDatagram(msg); err != nil {
+			log.Printf("Error while sending datagram. Error: %v", err)
+			return
+		}
+
+		reply, err := session.ReceiveDatagram()
+		if err != nil {
+			log.Printf("Error while receiving datagram. Error: %v", err)
+			return
+		}
+		log.Printf("Datagram reply: %s", reply)
+
+		time.Sleep(1 * time.Second)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	_ "net/http/pprof"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/Wowah/golang-HTTP3-example/webtransport"
+)
+
+// Setup a bare-bones TLS config for the server
+func generateTLSConfig() *tls.Config {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		panic(err)
+	}
+	template := x509.Certificate{SerialNumber: big.NewInt(1)}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+
+	tlsCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		panic(err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		NextProtos:   []string{"quic-echo-example"},
+	}
+}
+
+type Server struct {
+	wt webtransport.Server
+}
+
+// Main handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("New session request")
+
+	session, err := s.wt.Upgrade(w, r)
+	if err != nil {
+		log.Printf("Error while upgrading to a WebTransport session. Error: %v", err)
+		return
+	}
+	defer session.CloseWithError(0, "session ended")
+
+	// A stream carries bulk transfer: echo everything the peer sends
+	// on it back verbatim.
+	go func() {
+		str, err := session.AcceptStream(r.Context())
+		if err != nil {
+			log.Printf("Error while accepting stream. Error: %v", err)
+			return
+		}
+		defer str.Close()
+
+		if _, err := io.Copy(str, str); err != nil {
+			log.Printf("Error while echoing stream. Error: %v", err)
+		}
+	}()
+
+	// Datagrams carry latency-sensitive messages: bounce each one
+	// straight back without going through a stream at all.
+	for i := 0; i < 10; i++ {
+		msg, err := session.ReceiveDatagram()
+		if err != nil {
+			log.Printf("Error while receiving datagram. Error: %v", err)
+			return
+		}
+		log.Printf("Datagram from client: %s", msg)
+
+		if err := session.SendDatagram(msg); err != nil {
+			log.Printf("Error while sending datagram. Error: %v", err)
+			return
+		}
+
+		time.Sleep(1 * time.Second)
+	}
+}
+
+func main() {
+	srv := &Server{
+		wt: webtransport.Server{
+			H3: http3.Server{
+				Addr:            "localhost:8082",
+				TLSConfig:       generateTLSConfig(),
+				EnableDatagrams: true,
+			},
+			CheckOrigin: func(r *http.Request) bool {
+				// Local example only: accept every origin.
+				return true
+			},
+		},
+	}
+	srv.wt.H3.Handler = srv
+
+	if err := srv.wt.ListenAndServe(); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
@@ -0,0 +1,456 @@
+// Package webtransport turns the StatusSwitchingProtocols +
+// DataStreamer hack this repo's echo server used into a reusable
+// session abstraction: a WebTransport-style session multiplexes extra
+// bidirectional and unidirectional QUIC streams, plus unreliable
+// datagrams, underneath a single HTTP/3 request.
+package webtransport
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+// Protocol is the :protocol value WebTransport sessions negotiate via
+// RFC 9220 extended CONNECT. quic-go's http3 package doesn't expose
+// the :protocol pseudo-header through http.Header (Header.Set rejects
+// the colon-prefixed name outright); instead it reads and writes the
+// pseudo-header through the standard library's otherwise-unused
+// Request.Proto field, so that's where Dial and Upgrade negotiate it
+// too.
+const Protocol = "webtransport"
+
+// streamFrameType and uniStreamFrameType tag a QUIC stream a session
+// opened beyond its own request stream, immediately followed by a
+// varint session ID (the StreamID of the request stream the session
+// was negotiated on). quic-go's HTTP/3 layer treats every stream a
+// peer opens as a new request (or, for unidirectional streams, an
+// unknown stream type) unless told otherwise, so a session can't just
+// open a second stream on the connection and have the other side hand
+// it to the right Session - the StreamHijacker/UniStreamHijacker
+// wired into both Server and Dial's round tripper read this tag first
+// and route the stream there instead.
+const (
+	streamFrameType    http3.FrameType  = 0x41
+	uniStreamFrameType http3.StreamType = 0x54
+)
+
+// sessionConn is the subset of quic.Connection a Session needs beyond
+// what its own request stream already gives it: opening more streams
+// and tearing down the connection. It's satisfied by a type assertion
+// from http3.Hijacker's http3.Connection down to the real
+// quic.Connection - legitimate here because quic-go's http3.Connection
+// implementation embeds one.
+type sessionConn interface {
+	Context() context.Context
+	OpenStreamSync(ctx context.Context) (quic.Stream, error)
+	OpenUniStreamSync(ctx context.Context) (quic.SendStream, error)
+	CloseWithError(quic.ApplicationErrorCode, string) error
+}
+
+// sessionKey identifies one session among all the connections an
+// http3.Server may be handling: the connection's tracing ID plus the
+// session's own request stream ID, which is unique within that
+// connection but not across connections.
+type sessionKey struct {
+	conn quic.ConnectionTracingID
+	id   uint64
+}
+
+// CheckOrigin decides whether to accept a session based on its
+// originating request. Return false to reject it.
+type CheckOrigin func(r *http.Request) bool
+
+// Server upgrades incoming HTTP/3 requests into WebTransport
+// sessions. Callers serve H3 themselves (or via Serve/ListenAndServe,
+// which do it for them); either way Server wires the Stream/UniStream
+// hijackers it needs into H3 the first time it's used, so those two
+// fields must be left unset by the caller.
+type Server struct {
+	H3 http3.Server
+
+	// CheckOrigin gates session acceptance. A nil CheckOrigin accepts
+	// every origin, which is only appropriate for local examples.
+	CheckOrigin CheckOrigin
+
+	initOnce sync.Once
+	mu       sync.Mutex
+	sessions map[sessionKey]*Session
+}
+
+func (s *Server) init() {
+	s.sessions = make(map[sessionKey]*Session)
+
+	s.H3.StreamHijacker = func(ft http3.FrameType, connID quic.ConnectionTracingID, str quic.Stream, err error) (bool, error) {
+		if ft != streamFrameType {
+			return false, nil
+		}
+		id, err := quicvarint.Read(quicvarint.NewReader(str))
+		if err != nil {
+			return false, err
+		}
+
+		session, ok := s.lookupSession(connID, id)
+		if !ok {
+			str.CancelRead(0)
+			str.CancelWrite(0)
+			return true, nil
+		}
+		session.streams <- str
+		return true, nil
+	}
+
+	s.H3.UniStreamHijacker = func(st http3.StreamType, connID quic.ConnectionTracingID, str quic.ReceiveStream, err error) bool {
+		if st != uniStreamFrameType {
+			return false
+		}
+		id, err := quicvarint.Read(quicvarint.NewReader(str))
+		if err != nil {
+			return true
+		}
+
+		session, ok := s.lookupSession(connID, id)
+		if !ok {
+			str.CancelRead(0)
+			return true
+		}
+		session.uniStreams <- str
+		return true
+	}
+}
+
+func (s *Server) lookupSession(connID quic.ConnectionTracingID, id uint64) (*Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[sessionKey{connID, id}]
+	return session, ok
+}
+
+// Serve wires up the hijackers and serves HTTP/3 over conn.
+func (s *Server) Serve(conn net.PacketConn) error {
+	s.initOnce.Do(s.init)
+	return s.H3.Serve(conn)
+}
+
+// ListenAndServe wires up the hijackers and listens for HTTP/3
+// connections on H3.Addr.
+func (s *Server) ListenAndServe() error {
+	s.initOnce.Do(s.init)
+	return s.H3.ListenAndServe()
+}
+
+// Close tears down H3 and every session still being tracked.
+func (s *Server) Close() error {
+	return s.H3.Close()
+}
+
+// Upgrade negotiates a session on r. It prefers the RFC 9220 extended
+// CONNECT handshake (a CONNECT request with Proto == Protocol) and
+// falls back to the StatusSwitchingProtocols upgrade for legacy
+// clients that predate extended CONNECT support.
+func (s *Server) Upgrade(w http.ResponseWriter, r *http.Request) (*Session, error) {
+	s.initOnce.Do(s.init)
+
+	if s.CheckOrigin != nil && !s.CheckOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return nil, errors.New("webtransport: origin rejected")
+	}
+
+	hijacker, ok := w.(http3.Hijacker)
+	if !ok {
+		return nil, errors.New("webtransport: response writer does not support hijacking the connection")
+	}
+	streamer, ok := w.(http3.HTTPStreamer)
+	if !ok {
+		return nil, errors.New("webtransport: response writer does not support taking over its stream")
+	}
+	conn, ok := hijacker.Connection().(sessionConn)
+	if !ok {
+		return nil, errors.New("webtransport: http3.Connection does not expose the underlying QUIC connection")
+	}
+
+	if r.Method == http.MethodConnect && r.Proto == Protocol {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusSwitchingProtocols)
+	}
+
+	str := streamer.HTTPStream()
+	key := sessionKey{
+		conn: conn.Context().Value(quic.ConnectionTracingKey).(quic.ConnectionTracingID),
+		id:   uint64(str.StreamID()),
+	}
+	session := newSession(str, conn, key.id)
+
+	s.mu.Lock()
+	s.sessions[key] = session
+	s.mu.Unlock()
+	go func() {
+		// A well-behaved peer ends the session with CloseWithError,
+		// which cancels session.ctx directly. A peer that disappears
+		// without one - crash, network partition, a stream reset that
+		// races the handler's deferred CloseWithError - never does
+		// that, so also watch the request stream's own context (done
+		// as soon as either direction of it closes) and the QUIC
+		// connection's (done when the whole connection goes away) so
+		// a dead peer still gets reaped instead of leaking this
+		// goroutine and its sessions map entry for the server's life.
+		select {
+		case <-session.ctx.Done():
+		case <-str.Context().Done():
+		case <-conn.Context().Done():
+		}
+		session.ctxCancel()
+		s.mu.Lock()
+		delete(s.sessions, key)
+		s.mu.Unlock()
+	}()
+
+	return session, nil
+}
+
+// Dial negotiates a WebTransport session with the server at addr,
+// dialing a fresh QUIC connection for it. Unlike an http3.RoundTripper,
+// which hides its connections and wraps resp.Body for ordinary HTTP
+// body framing, a session needs the raw request stream and the QUIC
+// connection it rode in on - so Dial owns the connection itself and
+// opens the request stream directly via http3.SingleDestinationRoundTripper,
+// the same mechanism http3.ResponseWriter's Hijacker/HTTPStreamer use
+// on the server side. Every error path after qconn is dialed closes
+// it, since Dial is its sole owner until it hands a *Session back.
+func Dial(ctx context.Context, addr string, tlsConf *tls.Config, quicConf *quic.Config, urlStr string) (*Session, error) {
+	// Datagrams are part of the session API (SendDatagram/ReceiveDatagram),
+	// so Dial enables them at the QUIC layer itself rather than making
+	// every caller remember to.
+	qc := quic.Config{}
+	if quicConf != nil {
+		qc = *quicConf
+	}
+	qc.EnableDatagrams = true
+
+	qconn, err := quic.DialAddrEarly(ctx, addr, tlsConf, &qc)
+	if err != nil {
+		return nil, fmt.Errorf("webtransport: dialing QUIC connection: %w", err)
+	}
+
+	// sessionPtr is filled in as soon as the request stream exists,
+	// before SendRequestHeader ever reaches the peer - a server can't
+	// legitimately tag a stream for this session until it has learned
+	// our stream's ID, which happens no earlier than that. Going
+	// through an atomic.Pointer rather than a plain *Session gives the
+	// StreamHijacker/UniStreamHijacker goroutines - which
+	// OpenRequestStream starts running before returning - a
+	// synchronized read instead of a bare data race, and lets them
+	// fail closed if a (misbehaving, or simply too-fast) peer somehow
+	// beats the assignment.
+	var sessionPtr atomic.Pointer[Session]
+	rt := &http3.SingleDestinationRoundTripper{
+		Connection:      qconn,
+		EnableDatagrams: true,
+		StreamHijacker: func(ft http3.FrameType, _ quic.ConnectionTracingID, str quic.Stream, err error) (bool, error) {
+			if ft != streamFrameType {
+				return false, nil
+			}
+			if _, err := quicvarint.Read(quicvarint.NewReader(str)); err != nil {
+				return false, err
+			}
+			session := sessionPtr.Load()
+			if session == nil {
+				return false, errors.New("webtransport: stream tagged for a session that isn't ready yet")
+			}
+			session.streams <- str
+			return true, nil
+		},
+		UniStreamHijacker: func(st http3.StreamType, _ quic.ConnectionTracingID, str quic.ReceiveStream, err error) bool {
+			if st != uniStreamFrameType {
+				return false
+			}
+			if _, err := quicvarint.Read(quicvarint.NewReader(str)); err != nil {
+				return true
+			}
+			session := sessionPtr.Load()
+			if session == nil {
+				return true
+			}
+			session.uniStreams <- str
+			return true
+		},
+	}
+
+	str, err := rt.OpenRequestStream(ctx)
+	if err != nil {
+		qconn.CloseWithError(0, "")
+		return nil, fmt.Errorf("webtransport: opening request stream: %w", err)
+	}
+
+	session := newSession(str, qconn, uint64(str.StreamID()))
+	sessionPtr.Store(session)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodConnect, urlStr, nil)
+	if err != nil {
+		session.ctxCancel()
+		qconn.CloseWithError(0, "")
+		return nil, err
+	}
+	req.Proto = Protocol
+
+	if err := str.SendRequestHeader(req); err != nil {
+		session.ctxCancel()
+		qconn.CloseWithError(0, "")
+		return nil, fmt.Errorf("webtransport: sending request: %w", err)
+	}
+
+	resp, err := str.ReadResponse()
+	if err != nil {
+		session.ctxCancel()
+		qconn.CloseWithError(0, "")
+		return nil, fmt.Errorf("webtransport: reading response: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusSwitchingProtocols:
+	default:
+		session.ctxCancel()
+		str.Close()
+		qconn.CloseWithError(0, "")
+		return nil, errors.New("webtransport: server refused to upgrade the session")
+	}
+
+	return session, nil
+}
+
+// Session is a WebTransport-style session: one HTTP/3 request stream
+// plus the extra streams and datagrams multiplexed onto the same
+// QUIC connection on its behalf.
+type Session struct {
+	// Stream is the request stream the session was negotiated on. Most
+	// callers should prefer OpenStreamSync/AcceptStream for the bulk
+	// of their traffic and leave this one for session-level framing.
+	Stream http3.Stream
+
+	conn sessionConn
+	id   uint64
+
+	ctx        context.Context
+	ctxCancel  context.CancelFunc
+	streams    chan quic.Stream
+	uniStreams chan quic.ReceiveStream
+}
+
+func newSession(str http3.Stream, conn sessionConn, id uint64) *Session {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Session{
+		Stream:     str,
+		conn:       conn,
+		id:         id,
+		ctx:        ctx,
+		ctxCancel:  cancel,
+		streams:    make(chan quic.Stream, 8),
+		uniStreams: make(chan quic.ReceiveStream, 8),
+	}
+}
+
+// AcceptStream blocks until the peer opens a new bidirectional stream
+// for this session via OpenStreamSync, or ctx is done.
+func (s *Session) AcceptStream(ctx context.Context) (quic.Stream, error) {
+	select {
+	case str := <-s.streams:
+		return str, nil
+	case <-s.ctx.Done():
+		return nil, errors.New("webtransport: session closed")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// OpenStreamSync opens a new bidirectional stream to the peer,
+// tagging it with streamFrameType and this session's ID so the
+// receiving side's StreamHijacker routes it back to this session
+// instead of treating it as a new HTTP/3 request.
+func (s *Session) OpenStreamSync(ctx context.Context) (quic.Stream, error) {
+	str, err := s.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := quicvarint.Append(nil, uint64(streamFrameType))
+	hdr = quicvarint.Append(hdr, s.id)
+	if _, err := str.Write(hdr); err != nil {
+		return nil, fmt.Errorf("webtransport: tagging stream: %w", err)
+	}
+	return str, nil
+}
+
+// AcceptUniStream blocks until the peer opens a new unidirectional
+// stream for this session via OpenUniStreamSync, or ctx is done.
+func (s *Session) AcceptUniStream(ctx context.Context) (quic.ReceiveStream, error) {
+	select {
+	case str := <-s.uniStreams:
+		return str, nil
+	case <-s.ctx.Done():
+		return nil, errors.New("webtransport: session closed")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// OpenUniStreamSync opens a new unidirectional stream to the peer,
+// tagging it with uniStreamFrameType and this session's ID so the
+// receiving side's UniStreamHijacker routes it back to this session.
+func (s *Session) OpenUniStreamSync(ctx context.Context) (quic.SendStream, error) {
+	str, err := s.conn.OpenUniStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := quicvarint.Append(nil, uint64(uniStreamFrameType))
+	hdr = quicvarint.Append(hdr, s.id)
+	if _, err := str.Write(hdr); err != nil {
+		return nil, fmt.Errorf("webtransport: tagging uni stream: %w", err)
+	}
+	return str, nil
+}
+
+// SendDatagram sends an unreliable, unordered message alongside the
+// session's streams - useful for latency-sensitive messages that
+// shouldn't wait behind head-of-line blocked stream data. It rides on
+// the session's own request stream's HTTP/3 datagram flow (RFC 9297),
+// which quic-go's http3 layer already demultiplexes per request
+// stream - so, unlike the bidirectional/unidirectional streams above,
+// it needs no tagging of its own to reach the right Session when
+// several share one connection.
+func (s *Session) SendDatagram(b []byte) error {
+	return s.Stream.SendDatagram(b)
+}
+
+// ReceiveDatagram blocks until a datagram addressed to this session
+// arrives, or the session is closed.
+func (s *Session) ReceiveDatagram() ([]byte, error) {
+	return s.Stream.ReceiveDatagram(s.ctx)
+}
+
+// CloseWithError notifies the peer with an application error code by
+// resetting the session's own request stream, rather than closing the
+// whole underlying QUIC connection - other sessions or requests
+// sharing that connection are left alone. reason has no wire
+// representation for a stream reset (unlike CloseWithError on a
+// connection); it's accepted for symmetry with that API and so a
+// future move to the WebTransport CLOSE_WEBTRANSPORT_SESSION capsule,
+// which does carry one, doesn't change this method's signature.
+func (s *Session) CloseWithError(code uint64, reason string) error {
+	s.ctxCancel()
+
+	errCode := quic.StreamErrorCode(code)
+	s.Stream.CancelWrite(errCode)
+	s.Stream.CancelRead(errCode)
+	return nil
+}
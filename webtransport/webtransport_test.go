@@ -0,0 +1,257 @@
+package webtransport
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// generateTestTLSConfigs returns a self-signed server TLS config and a
+// matching client config that trusts it, so Dial/Upgrade can be
+// exercised end to end over a real local QUIC connection.
+func generateTestTLSConfigs(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	template := x509.Certificate{SerialNumber: big.NewInt(1)}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	tlsCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("loading key pair: %v", err)
+	}
+
+	return &tls.Config{Certificates: []tls.Certificate{tlsCert}, NextProtos: []string{"h3"}},
+		&tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h3"}}
+}
+
+// TestUpgradeAndDialEchoStream is a smoke test proving Upgrade and Dial
+// actually interoperate against the real quic-go v0.45.2 http3 API:
+// Dial negotiates a session against an Upgrade-d handler over a real
+// local QUIC connection, opens a stream, and round-trips a message on
+// it.
+func TestUpgradeAndDialEchoStream(t *testing.T) {
+	serverConf, clientConf := generateTestTLSConfigs(t)
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	defer udpConn.Close()
+
+	serverErrs := make(chan error, 1)
+	wt := &Server{
+		H3: http3.Server{TLSConfig: serverConf},
+	}
+	wt.H3.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := wt.Upgrade(w, r)
+		if err != nil {
+			serverErrs <- fmt.Errorf("Upgrade: %w", err)
+			return
+		}
+		str, err := session.AcceptStream(r.Context())
+		if err != nil {
+			serverErrs <- fmt.Errorf("AcceptStream: %w", err)
+			return
+		}
+		defer str.Close()
+
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(str, buf); err != nil {
+			serverErrs <- fmt.Errorf("server Read: %w", err)
+			return
+		}
+		if _, err := str.Write(buf); err != nil {
+			serverErrs <- fmt.Errorf("server Write: %w", err)
+			return
+		}
+		serverErrs <- nil
+	})
+	go wt.Serve(udpConn)
+	defer wt.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addr := udpConn.LocalAddr().(*net.UDPAddr)
+	session, err := Dial(ctx, fmt.Sprintf("127.0.0.1:%d", addr.Port), clientConf, nil, "https://127.0.0.1/")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer session.CloseWithError(0, "done")
+
+	str, err := session.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatalf("OpenStreamSync: %v", err)
+	}
+	if _, err := str.Write([]byte("hello")); err != nil {
+		t.Fatalf("client Write: %v", err)
+	}
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(str, buf); err != nil {
+		t.Fatalf("client Read: %v", err)
+	}
+	if string(buf) != "hello" {
+		t.Fatalf("got %q, want %q", buf, "hello")
+	}
+
+	if err := <-serverErrs; err != nil {
+		t.Fatalf("server handler: %v", err)
+	}
+}
+
+// TestUpgradeAndDialEchoDatagram proves a datagram sent through
+// Session.SendDatagram/ReceiveDatagram survives the round trip intact -
+// datagrams need no session-ID tagging of their own, since they ride
+// the session's own request stream's HTTP/3 datagram flow.
+func TestUpgradeAndDialEchoDatagram(t *testing.T) {
+	serverConf, clientConf := generateTestTLSConfigs(t)
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	defer udpConn.Close()
+
+	serverErrs := make(chan error, 1)
+	wt := &Server{
+		H3: http3.Server{TLSConfig: serverConf, EnableDatagrams: true},
+	}
+	wt.H3.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := wt.Upgrade(w, r)
+		if err != nil {
+			serverErrs <- fmt.Errorf("Upgrade: %w", err)
+			return
+		}
+		defer session.CloseWithError(0, "done")
+
+		msg, err := session.ReceiveDatagram()
+		if err != nil {
+			serverErrs <- fmt.Errorf("server ReceiveDatagram: %w", err)
+			return
+		}
+		if err := session.SendDatagram(msg); err != nil {
+			serverErrs <- fmt.Errorf("server SendDatagram: %w", err)
+			return
+		}
+		serverErrs <- nil
+	})
+	go wt.Serve(udpConn)
+	defer wt.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	addr := udpConn.LocalAddr().(*net.UDPAddr)
+
+	session, err := Dial(ctx, fmt.Sprintf("127.0.0.1:%d", addr.Port), clientConf, nil, "https://127.0.0.1/")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer session.CloseWithError(0, "done")
+
+	if err := session.SendDatagram([]byte("ping")); err != nil {
+		t.Fatalf("SendDatagram: %v", err)
+	}
+
+	got, err := session.ReceiveDatagram()
+	if err != nil {
+		t.Fatalf("ReceiveDatagram: %v", err)
+	}
+	if string(got) != "ping" {
+		t.Fatalf("got %q, want %q", got, "ping")
+	}
+
+	if err := <-serverErrs; err != nil {
+		t.Fatalf("server handler: %v", err)
+	}
+}
+
+// TestUpgradeAndDialUniStream proves a unidirectional stream the
+// client opens with OpenUniStreamSync reaches the server's
+// AcceptUniStream tagged with the right session, exercising the
+// Server's UniStreamHijacker wiring.
+func TestUpgradeAndDialUniStream(t *testing.T) {
+	serverConf, clientConf := generateTestTLSConfigs(t)
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	defer udpConn.Close()
+
+	serverErrs := make(chan error, 1)
+	wt := &Server{
+		H3: http3.Server{TLSConfig: serverConf},
+	}
+	wt.H3.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := wt.Upgrade(w, r)
+		if err != nil {
+			serverErrs <- fmt.Errorf("Upgrade: %w", err)
+			return
+		}
+
+		str, err := session.AcceptUniStream(r.Context())
+		if err != nil {
+			serverErrs <- fmt.Errorf("AcceptUniStream: %w", err)
+			return
+		}
+
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(str, buf); err != nil {
+			serverErrs <- fmt.Errorf("server Read: %w", err)
+			return
+		}
+		if string(buf) != "hello" {
+			serverErrs <- fmt.Errorf("server got %q, want %q", buf, "hello")
+			return
+		}
+		serverErrs <- nil
+	})
+	go wt.Serve(udpConn)
+	defer wt.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addr := udpConn.LocalAddr().(*net.UDPAddr)
+	session, err := Dial(ctx, fmt.Sprintf("127.0.0.1:%d", addr.Port), clientConf, nil, "https://127.0.0.1/")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer session.CloseWithError(0, "done")
+
+	str, err := session.OpenUniStreamSync(ctx)
+	if err != nil {
+		t.Fatalf("OpenUniStreamSync: %v", err)
+	}
+	if _, err := str.Write([]byte("hello")); err != nil {
+		t.Fatalf("client Write: %v", err)
+	}
+	if err := str.Close(); err != nil {
+		t.Fatalf("client Close: %v", err)
+	}
+
+	if err := <-serverErrs; err != nil {
+		t.Fatalf("server handler: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+// Package zerortt provides the two pieces the example client and
+// server were missing to make QUIC/HTTP3 0-RTT (http3.MethodGet0RTT)
+// actually do anything: a persistent client session ticket cache, so
+// 0-RTT can engage on the very first connection a fresh process
+// makes, and a server-side replay-safety gate for handlers that
+// aren't safe to run twice.
+package zerortt
+
+import (
+	"crypto/tls"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ticketEntry is what FileSessionCache persists per key.
+type ticketEntry struct {
+	Ticket  []byte
+	State   []byte
+	Expires time.Time
+}
+
+// FileSessionCache is a tls.ClientSessionCache backed by a gob-encoded
+// file on disk, so sessions - and therefore 0-RTT - survive the
+// client process restarting. Entries are namespaced by the ALPN
+// protocol passed to NewFileSessionCache, since crypto/tls itself
+// keys Get/Put by server name only and a ticket negotiated under one
+// ALPN can't be resumed under another.
+type FileSessionCache struct {
+	path string
+	alpn string
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]ticketEntry
+}
+
+// NewFileSessionCache loads path if it exists and returns a cache
+// that persists every Put back to it. Expired entries are evicted on
+// load and on every Put; Get evicts only the entry it looks up, and
+// only if that entry has expired.
+func NewFileSessionCache(path, alpn string, ttl time.Duration) (*FileSessionCache, error) {
+	c := &FileSessionCache{
+		path:    path,
+		alpn:    alpn,
+		ttl:     ttl,
+		entries: make(map[string]ticketEntry),
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("zerortt: opening session cache: %w", err)
+	}
+	defer f.Close()
+
+	if err := gob.NewDecoder(f).Decode(&c.entries); err != nil {
+		return nil, fmt.Errorf("zerortt: decoding session cache: %w", err)
+	}
+	c.evictLocked()
+
+	return c, nil
+}
+
+// Get implements tls.ClientSessionCache.
+func (c *FileSessionCache) Get(key string) (*tls.ClientSessionState, bool) {
+	key = c.namespaced(key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.Expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	state, err := tls.ParseSessionState(entry.State)
+	if err != nil {
+		delete(c.entries, key)
+		return nil, false
+	}
+	cs, err := tls.NewResumptionState(entry.Ticket, state)
+	if err != nil {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return cs, true
+}
+
+// Put implements tls.ClientSessionCache. A nil cs evicts key, which
+// is how crypto/tls signals that a session turned out to be
+// unresumable.
+func (c *FileSessionCache) Put(key string, cs *tls.ClientSessionState) {
+	key = c.namespaced(key)
+
+	if cs == nil {
+		c.mu.Lock()
+		delete(c.entries, key)
+		c.mu.Unlock()
+		c.persist()
+		return
+	}
+
+	ticket, state, err := cs.ResumptionState()
+	if err != nil {
+		return
+	}
+	raw, err := state.Bytes()
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.entries[key] = ticketEntry{
+		Ticket:  ticket,
+		State:   raw,
+		Expires: time.Now().Add(c.ttl),
+	}
+	c.evictLocked()
+	c.mu.Unlock()
+
+	c.persist()
+}
+
+func (c *FileSessionCache) namespaced(key string) string {
+	return c.alpn + "|" + key
+}
+
+func (c *FileSessionCache) evictLocked() {
+	now := time.Now()
+	for k, e := range c.entries {
+		if now.After(e.Expires) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// persist writes the current entry set to disk. It's best-effort: a
+// failed write just means the next process starts cold, and
+// tls.ClientSessionCache's Put has no error return to surface it
+// through. The file is opened 0600: crypto/tls's own documentation for
+// SessionState.Bytes calls this payload secret values critical to the
+// security of future and possibly past sessions, so it must not be
+// left world-readable at the default os.Create permissions.
+func (c *FileSessionCache) persist() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f, err := os.OpenFile(c.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	_ = gob.NewEncoder(f).Encode(c.entries)
+}
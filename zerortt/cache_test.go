@@ -0,0 +1,70 @@
+package zerortt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSessionCacheNamespacesByALPN(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.gob")
+	c, err := NewFileSessionCache(path, "h3", time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileSessionCache: %v", err)
+	}
+	if got, want := c.namespaced("example.com"), "h3|example.com"; got != want {
+		t.Fatalf("namespaced(%q) = %q, want %q", "example.com", got, want)
+	}
+}
+
+func TestFileSessionCacheGetEvictsExpiredEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.gob")
+	c, err := NewFileSessionCache(path, "h3", time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileSessionCache: %v", err)
+	}
+	c.entries["h3|host"] = ticketEntry{Expires: time.Now().Add(-time.Minute)}
+
+	if _, ok := c.Get("host"); ok {
+		t.Fatal("expected Get to report a miss for an expired entry")
+	}
+	if _, ok := c.entries["h3|host"]; ok {
+		t.Fatal("expected Get to evict the expired entry")
+	}
+}
+
+func TestFileSessionCachePersistsAcrossReloadAndEvictsStaleEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.gob")
+
+	c, err := NewFileSessionCache(path, "h3", time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileSessionCache: %v", err)
+	}
+	c.entries["h3|fresh"] = ticketEntry{Ticket: []byte("t1"), Expires: time.Now().Add(time.Hour)}
+	c.entries["h3|stale"] = ticketEntry{Ticket: []byte("t2"), Expires: time.Now().Add(-time.Hour)}
+	c.persist()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Fatalf("session cache file mode = %v, want 0600 since it holds secret ticket state", perm)
+	}
+
+	reloaded, err := NewFileSessionCache(path, "h3", time.Hour)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if _, ok := reloaded.entries["h3|stale"]; ok {
+		t.Fatal("expected stale entry to be evicted on load")
+	}
+	entry, ok := reloaded.entries["h3|fresh"]
+	if !ok {
+		t.Fatal("expected fresh entry to survive a gob round-trip through disk")
+	}
+	if string(entry.Ticket) != "t1" {
+		t.Fatalf("ticket after gob round-trip = %q, want %q", entry.Ticket, "t1")
+	}
+}
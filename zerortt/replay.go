@@ -0,0 +1,57 @@
+package zerortt
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// Metrics counts 0-RTT outcomes so operators can observe rollout
+// before and after enabling RequireReplaySafe on a handler.
+type Metrics struct {
+	accepted uint64
+	rejected uint64
+	replayed uint64
+}
+
+// Accepted returns the number of 0-RTT requests that were let
+// through to the handler.
+func (m *Metrics) Accepted() uint64 { return atomic.LoadUint64(&m.accepted) }
+
+// Rejected returns the number of 0-RTT requests turned away with 425
+// Too Early because their handler wasn't marked idempotent.
+func (m *Metrics) Rejected() uint64 { return atomic.LoadUint64(&m.rejected) }
+
+// Replayed returns the number of 0-RTT requests known to be replays
+// of an earlier attempt. Nothing in this package detects replay
+// itself - detection happens below it, in whatever anti-replay cache
+// guards the QUIC resumption token - callers that have one should
+// call RecordReplay when it reports a collision.
+func (m *Metrics) Replayed() uint64 { return atomic.LoadUint64(&m.replayed) }
+
+// RecordReplay increments the Replayed counter. Call it from the
+// anti-replay cache that actually recognizes the duplicate.
+func (m *Metrics) RecordReplay() { atomic.AddUint64(&m.replayed, 1) }
+
+// RequireReplaySafe wraps handler with a gate on 0-RTT (early data)
+// requests. While a request is served from 0-RTT, r.TLS.HandshakeComplete
+// is still false: the client's Finished message hasn't been verified
+// yet, so an attacker who captured and replayed the ClientHello could
+// have triggered this same handler invocation more than once. Unless
+// idempotent is true, such requests are rejected with 425 Too Early
+// instead of reaching handler.
+func RequireReplaySafe(handler http.Handler, idempotent bool, metrics *Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && !r.TLS.HandshakeComplete {
+			if !idempotent {
+				metrics.rejectedInc()
+				http.Error(w, "too early", http.StatusTooEarly)
+				return
+			}
+			metrics.acceptedInc()
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (m *Metrics) acceptedInc() { atomic.AddUint64(&m.accepted, 1) }
+func (m *Metrics) rejectedInc() { atomic.AddUint64(&m.rejected, 1) }